@@ -0,0 +1,222 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CheckpointResult reports the outcome of a Checkpoint run: how many
+// segments were folded into the checkpoint, how many of their entries
+// survived the keep predicate, and how many on-disk bytes were reclaimed by
+// dropping the rest.
+type CheckpointResult struct {
+	SegmentsCompacted int
+	EntriesKept       int
+	EntriesDropped    int
+	BytesReclaimed    int64
+}
+
+// Checkpoint compacts every closed segment with index <= upto, keeping only
+// the entries for which keep returns true. Kept entries are re-framed into
+// new segment files written under "<dir>/checkpoint.NNNNNN.tmp/", fsynced,
+// and the directory is atomically renamed to "<dir>/checkpoint.NNNNNN"
+// before the superseded segments are removed. LoadSegments recognizes that
+// directory on the next startup and loads it in place of the segments it
+// replaced.
+func (w *WAL) Checkpoint(ctx context.Context, upto int, keep func(entry *LogEntry) bool) (CheckpointResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.checkpoint(ctx, upto, keep)
+}
+
+// checkpoint is Checkpoint's implementation, callable by code that already
+// holds w.mu (flushBuffer's WithCheckpointOnRotate hook).
+func (w *WAL) checkpoint(ctx context.Context, upto int, keep func(entry *LogEntry) bool) (CheckpointResult, error) {
+	var result CheckpointResult
+
+	tmpDir := filepath.Join(w.option.dir, fmt.Sprintf("checkpoint.%06d.tmp", upto))
+	finalDir := filepath.Join(w.option.dir, fmt.Sprintf("checkpoint.%06d", upto))
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return result, err
+	}
+
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return result, err
+	}
+
+	kept := make([]*Segment, 0, len(w.segments))
+	toRemove := make([]*Segment, 0)
+
+	for _, seg := range w.segments {
+		if err := ctx.Err(); err != nil {
+			os.RemoveAll(tmpDir)
+			return result, err
+		}
+
+		if seg.index > upto || !seg.closed {
+			kept = append(kept, seg)
+			continue
+		}
+
+		newSeg, entriesKept, entriesDropped, err := checkpointSegment(seg, tmpDir, w.option.pageSize, w.option.keyIndexInterval, keep)
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return result, err
+		}
+
+		result.SegmentsCompacted++
+		result.EntriesKept += entriesKept
+		result.EntriesDropped += entriesDropped
+		result.BytesReclaimed += seg.currSize - newSeg.currSize
+
+		kept = append(kept, newSeg)
+		toRemove = append(toRemove, seg)
+	}
+
+	if result.SegmentsCompacted == 0 {
+		return result, os.RemoveAll(tmpDir)
+	}
+
+	if err := os.Rename(tmpDir, finalDir); err != nil {
+		return result, err
+	}
+
+	for i, seg := range kept {
+		if seg.closed && filepath.Dir(seg.path) == tmpDir {
+			kept[i].path = filepath.Join(finalDir, filepath.Base(seg.path))
+		}
+	}
+
+	for _, seg := range toRemove {
+		_ = seg.fd.Close()
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+
+		if err := removeSegmentSidecars(seg.path); err != nil {
+			return result, err
+		}
+	}
+
+	w.segments = kept
+	w.pos = 0
+	for _, seg := range w.segments {
+		w.pos += len(seg.offset)
+	}
+	w.lru.Purge()
+
+	return result, nil
+}
+
+// checkpointSegment re-frames the entries of seg for which keep returns
+// true into a new segment file of the same name under dir, preserving
+// seg's index so the rest of the WAL's segment ordering is untouched.
+func checkpointSegment(seg *Segment, dir string, pageSize int64, keyIndexInterval int, keep func(entry *LogEntry) bool) (*Segment, int, int, error) {
+	newPath := filepath.Join(dir, filepath.Base(seg.path))
+
+	newFile, err := os.OpenFile(newPath, createLogPermission, 0644)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := writeSegmentMeta(segmentMetaPath(newPath), pageSize); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := seg.Open(); err != nil {
+		return nil, 0, 0, err
+	}
+	defer seg.Close()
+
+	writer := bufio.NewWriter(newFile)
+
+	var (
+		size    int64
+		offsets = make([]pos, 0, len(seg.offset))
+		entKept int
+		dropped int
+	)
+
+	for _, p := range seg.offset {
+		entry, err := seg.SeekOffset(p.offset)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if !keep(entry) {
+			dropped++
+			continue
+		}
+		entKept++
+
+		logical := encodeLogical(entry)
+		start := size
+		encoded, newSize := encodeFragments(pageSize, start, logical)
+
+		if _, err := writer.Write(encoded); err != nil {
+			return nil, 0, 0, err
+		}
+
+		offsets = append(offsets, pos{offset: start, length: entry.Length, span: newSize - start})
+		size = newSize
+	}
+
+	if err := writer.Flush(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := newFile.Sync(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	stat, err := newFile.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	newSeg := &Segment{
+		index:    seg.index,
+		path:     newPath,
+		size:     size,
+		fd:       newFile,
+		writer:   writer,
+		currSize: stat.Size(),
+		modTime:  stat.ModTime(),
+		offset:   offsets,
+		closed:   true,
+		pageSize: pageSize,
+	}
+
+	keyIndex, err := rebuildKeyIndex(newSeg, keyIndexInterval)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	newSeg.keyIndex = keyIndex
+
+	if err := writeKeySidecar(keyIndexPath(newPath), keyIndex, keyIndexInterval); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return newSeg, entKept, dropped, nil
+}
+
+// encodeLogical rebuilds the 17-byte block header plus on-disk payload for
+// entry, so it can be re-fragmented into a new segment without
+// recompressing or re-timestamping it.
+func encodeLogical(entry *LogEntry) []byte {
+	logical := make([]byte, blockSize+len(entry.raw))
+
+	binary.BigEndian.PutUint64(logical[0:8], uint64(entry.Timestamp))
+	binary.BigEndian.PutUint32(logical[8:12], entry.Length)
+	binary.BigEndian.PutUint32(logical[12:16], entry.Checksum)
+	logical[16] = byte(entry.codec)
+	copy(logical[17:], entry.raw)
+
+	return logical
+}
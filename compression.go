@@ -0,0 +1,38 @@
+package wal
+
+import "github.com/golang/snappy"
+
+// CompressionCodec identifies how a record's payload was encoded before it
+// was framed onto disk. The codec is stored per-record in the block header,
+// so a single segment (and even a single WAL.Write call stream) can mix
+// codecs across its lifetime.
+type CompressionCodec uint8
+
+const (
+	// CompressionNone stores the payload as-is.
+	CompressionNone CompressionCodec = iota
+	// CompressionSnappy compresses the payload with Snappy before framing.
+	CompressionSnappy
+)
+
+// compress encodes data with codec, returning data unchanged for CompressionNone.
+func compress(codec CompressionCodec, data []byte) []byte {
+	switch codec {
+	case CompressionSnappy:
+		return snappy.Encode(nil, data)
+	default:
+		return data
+	}
+}
+
+// decompress reverses compress. It is driven by the codec byte read from the
+// block header rather than the WAL's configured option, so records written
+// under a different compression setting remain readable.
+func decompress(codec CompressionCodec, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	default:
+		return data, nil
+	}
+}
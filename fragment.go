@@ -0,0 +1,352 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// fragmentType identifies how a chunk of a logical record maps onto a page.
+// A record that fits entirely within the remaining space of a page is
+// written as a single Full fragment; a larger record is split across pages
+// as a First fragment, zero or more Middle fragments, and a final Last
+// fragment.
+type fragmentType uint8
+
+const (
+	// fragmentZero marks zero-padding left at the end of a page and is
+	// never written deliberately - it is what a reader sees when the
+	// remaining bytes of a page were padded out rather than used.
+	fragmentZero fragmentType = iota
+	fragmentFull
+	fragmentFirst
+	fragmentMiddle
+	fragmentLast
+)
+
+// fragHeaderSize is the per-fragment header: crc32(4) | length(2) | type(1).
+const fragHeaderSize = 7
+
+// encodeFragments splits logical into one or more fragments of at most
+// pageSize, starting at absolute byte offset. Whenever the remaining space
+// on a page can't hold a fragment header plus at least one byte, the rest
+// of the page is zero-padded and the record continues on the next page.
+// It returns the bytes to append to the segment verbatim and the absolute
+// offset immediately after the record.
+func encodeFragments(pageSize, offset int64, logical []byte) (encoded []byte, newOffset int64) {
+	var out bytes.Buffer
+
+	remaining := logical
+	first := true
+
+	for {
+		pageRemaining := pageSize - offset%pageSize
+		if pageRemaining < fragHeaderSize+1 {
+			out.Write(make([]byte, pageRemaining))
+			offset += pageRemaining
+			continue
+		}
+
+		avail := pageRemaining - fragHeaderSize
+
+		var chunk []byte
+		var typ fragmentType
+		if int64(len(remaining)) <= avail {
+			chunk = remaining
+			if first {
+				typ = fragmentFull
+			} else {
+				typ = fragmentLast
+			}
+		} else {
+			chunk = remaining[:avail]
+			if first {
+				typ = fragmentFirst
+			} else {
+				typ = fragmentMiddle
+			}
+		}
+
+		header := make([]byte, fragHeaderSize)
+		binary.BigEndian.PutUint32(header[0:4], crc32.ChecksumIEEE(chunk))
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(chunk)))
+		header[6] = byte(typ)
+
+		out.Write(header)
+		out.Write(chunk)
+
+		offset += int64(fragHeaderSize + len(chunk))
+		remaining = remaining[len(chunk):]
+		first = false
+
+		if len(remaining) == 0 {
+			break
+		}
+	}
+
+	return out.Bytes(), offset
+}
+
+// fragmentReader pulls fragments off r one at a time, tracking the absolute
+// byte position so it can tell page padding apart from real headers.
+type fragmentReader struct {
+	r        *bufio.Reader
+	pos      int64
+	pageSize int64
+}
+
+// next reads the next fragment, transparently skipping any zero-padding
+// left at the end of a page.
+func (fr *fragmentReader) next() (fragmentType, []byte, error) {
+	for {
+		pageRemaining := fr.pageSize - fr.pos%fr.pageSize
+		if pageRemaining < fragHeaderSize {
+			if _, err := io.CopyN(io.Discard, fr.r, pageRemaining); err != nil {
+				return 0, nil, err
+			}
+			fr.pos += pageRemaining
+			continue
+		}
+
+		header := make([]byte, fragHeaderSize)
+		if _, err := io.ReadFull(fr.r, header); err != nil {
+			return 0, nil, err
+		}
+		fr.pos += fragHeaderSize
+
+		crc := binary.BigEndian.Uint32(header[0:4])
+		length := binary.BigEndian.Uint16(header[4:6])
+		typ := fragmentType(header[6])
+
+		if typ == fragmentZero || int64(length) > pageRemaining-fragHeaderSize {
+			skip := fr.pageSize - fr.pos%fr.pageSize
+			if skip > 0 && skip < fr.pageSize {
+				if _, err := io.CopyN(io.Discard, fr.r, skip); err != nil {
+					return 0, nil, err
+				}
+				fr.pos += skip
+			}
+			continue
+		}
+
+		chunk := make([]byte, length)
+		if _, err := io.ReadFull(fr.r, chunk); err != nil {
+			return 0, nil, err
+		}
+		fr.pos += int64(length)
+
+		if crc32.ChecksumIEEE(chunk) != crc {
+			return 0, nil, fmt.Errorf("fragment checksum mismatch at offset %d", fr.pos-int64(length))
+		}
+
+		return typ, chunk, nil
+	}
+}
+
+// readLogical reassembles one logical record - a single Full fragment, or a
+// First followed by zero or more Middle and a closing Last - starting at
+// offset. It returns the reassembled bytes and the absolute offset
+// immediately after the record.
+func readLogical(r *bufio.Reader, pageSize, offset int64) ([]byte, int64, error) {
+	fr := &fragmentReader{r: r, pos: offset, pageSize: pageSize}
+
+	var buf bytes.Buffer
+	for {
+		typ, chunk, err := fr.next()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		buf.Write(chunk)
+
+		if typ == fragmentFull || typ == fragmentLast {
+			break
+		}
+	}
+
+	return buf.Bytes(), fr.pos, nil
+}
+
+// readAtLeast pulls fragments off fr until at least n bytes have been
+// accumulated, then splits them into the first n bytes (head) and whatever
+// payload bytes followed in that same fragment (rest). done reports whether
+// the fragment that produced rest was already the terminating Full/Last
+// fragment, so the caller knows not to pull any further.
+func readAtLeast(fr *fragmentReader, n int) (head, rest []byte, done bool, err error) {
+	return readAtLeastFrom(fr, nil, false, n)
+}
+
+// readAtLeastFrom is readAtLeast, but starting from bytes already pulled off
+// fr (initial) instead of an empty buffer, so a caller that consumed part of
+// the stream for one purpose (e.g. the fixed-size entry header) can keep
+// pulling for a second, unrelated purpose (e.g. a variable-length key
+// prefix) without losing the bytes it already has in hand.
+func readAtLeastFrom(fr *fragmentReader, initial []byte, initialDone bool, n int) (head, rest []byte, done bool, err error) {
+	buf := bytes.NewBuffer(initial)
+	done = initialDone
+
+	for buf.Len() < n && !done {
+		typ, chunk, err := fr.next()
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		buf.Write(chunk)
+		done = typ == fragmentFull || typ == fragmentLast
+	}
+
+	if buf.Len() < n {
+		return nil, nil, false, fmt.Errorf("corrupt record: expected %d bytes, record ended after %d", n, buf.Len())
+	}
+
+	all := buf.Bytes()
+	return all[:n], all[n:], done, nil
+}
+
+// EntryReader streams the payload of a single logical WAL entry fragment by
+// fragment instead of materializing the whole record in memory, verifying
+// each fragment's CRC as it is pulled off disk or the active buffer. It
+// tracks its own read position rather than sharing a *os.File cursor, so
+// concurrent EntryReaders opened on different indices never interfere with
+// each other.
+type EntryReader struct {
+	fr       *fragmentReader
+	file     *os.File
+	leftover []byte
+	done     bool
+}
+
+// newEntryReader builds an EntryReader for the logical record starting at
+// offset. Compressed payloads have no streaming decoder, so they are pulled
+// in full and decompressed up front; CompressionNone is the only codec an
+// EntryReader streams fragment by fragment without buffering the payload.
+func newEntryReader(reader *bufio.Reader, file *os.File, pageSize, offset int64) (*EntryReader, error) {
+	fr := &fragmentReader{r: reader, pos: offset, pageSize: pageSize}
+
+	head, rest, done, err := readAtLeast(fr, blockSize)
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(head[8:12])
+	codecByte := head[16]
+	codec := CompressionCodec(codecByte &^ hasKeyFlag)
+	hasKey := codecByte&hasKeyFlag != 0
+
+	if codec == CompressionNone {
+		if hasKey {
+			rest, done, err = stripKeyPrefix(fr, rest, done)
+			if err != nil {
+				if file != nil {
+					file.Close()
+				}
+				return nil, err
+			}
+		}
+
+		return &EntryReader{fr: fr, file: file, leftover: rest, done: done}, nil
+	}
+
+	raw := bytes.NewBuffer(rest)
+	for !done {
+		typ, chunk, err := fr.next()
+		if err != nil {
+			if file != nil {
+				file.Close()
+			}
+			return nil, err
+		}
+
+		raw.Write(chunk)
+		done = typ == fragmentFull || typ == fragmentLast
+	}
+
+	data, err := decompress(codec, raw.Bytes()[:length])
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, fmt.Errorf("decompress entry: %w", err)
+	}
+
+	if hasKey {
+		if len(data) < 2 {
+			if file != nil {
+				file.Close()
+			}
+			return nil, fmt.Errorf("corrupt record: short key header")
+		}
+
+		keyLen := int(binary.BigEndian.Uint16(data[0:2]))
+		if len(data) < 2+keyLen {
+			if file != nil {
+				file.Close()
+			}
+			return nil, fmt.Errorf("corrupt record: truncated key")
+		}
+
+		data = data[2+keyLen:]
+	}
+
+	return &EntryReader{file: file, leftover: data, done: true}, nil
+}
+
+// stripKeyPrefix consumes the 2-byte key length and key bytes that
+// WriteKeyed prepends to the plaintext payload, pulling further fragments
+// off fr if the prefix spans past what has already been read into rest.
+// It returns whatever payload bytes are left over after the prefix, and
+// whether the stream has already reached its terminating fragment.
+func stripKeyPrefix(fr *fragmentReader, rest []byte, done bool) ([]byte, bool, error) {
+	keyLenBytes, rest, done, err := readAtLeastFrom(fr, rest, done, 2)
+	if err != nil {
+		return nil, false, err
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(keyLenBytes))
+
+	_, rest, done, err = readAtLeastFrom(fr, rest, done, keyLen)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return rest, done, nil
+}
+
+func (er *EntryReader) Read(p []byte) (int, error) {
+	if len(er.leftover) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+
+		typ, chunk, err := er.fr.next()
+		if err != nil {
+			return 0, err
+		}
+
+		er.leftover = chunk
+		er.done = typ == fragmentFull || typ == fragmentLast
+	}
+
+	n := copy(p, er.leftover)
+	er.leftover = er.leftover[n:]
+
+	return n, nil
+}
+
+// Close releases the EntryReader's own file handle, if it opened one. A
+// reader serving an entry still in the active write buffer owns no handle
+// and Close is a no-op.
+func (er *EntryReader) Close() error {
+	if er.file != nil {
+		return er.file.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// keyIndexEntry locates one WriteKeyed entry by the key it was written
+// with: offset is the same logical-record offset used by pos.offset, so it
+// can be fed straight into Segment.SeekOffset or matched against
+// Segment.offset.
+type keyIndexEntry struct {
+	key    []byte
+	offset int64
+}
+
+// keyIndexPath returns the sidecar path a segment's sparse key index is
+// persisted under, e.g. "prefix-000006.log" -> "prefix-000006.idx".
+func keyIndexPath(segPath string) string {
+	return strings.TrimSuffix(segPath, filepath.Ext(segPath)) + ".idx"
+}
+
+// writeKeySidecar persists entries as a self-describing sparse key index:
+// a run of [key length(2) | key | offset(varint)] records followed by an
+// 8-byte trailer of [interval(4) | crc32(4)] so LoadSegments can validate
+// the file and recover interval without consulting the WALOption that
+// wrote it.
+func writeKeySidecar(path string, entries []keyIndexEntry, interval int) error {
+	var body bytes.Buffer
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+
+	for _, entry := range entries {
+		var lenBuf [2]byte
+		binary.BigEndian.PutUint16(lenBuf[:], uint16(len(entry.key)))
+		body.Write(lenBuf[:])
+		body.Write(entry.key)
+
+		n := binary.PutUvarint(varintBuf, uint64(entry.offset))
+		body.Write(varintBuf[:n])
+	}
+
+	var trailer [8]byte
+	binary.BigEndian.PutUint32(trailer[0:4], uint32(interval))
+	binary.BigEndian.PutUint32(trailer[4:8], crc32.ChecksumIEEE(body.Bytes()))
+	body.Write(trailer[:])
+
+	return os.WriteFile(path, body.Bytes(), 0644)
+}
+
+// readKeySidecar loads a sparse key index written by writeKeySidecar,
+// rejecting it if the trailing CRC doesn't match the entries that precede
+// it.
+func readKeySidecar(path string) ([]keyIndexEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8 {
+		return nil, fmt.Errorf("key index %s: truncated trailer", path)
+	}
+
+	body := data[:len(data)-8]
+	trailer := data[len(data)-8:]
+
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(trailer[4:8]) {
+		return nil, fmt.Errorf("key index %s: checksum mismatch", path)
+	}
+
+	var entries []keyIndexEntry
+	for len(body) > 0 {
+		if len(body) < 2 {
+			return nil, fmt.Errorf("key index %s: truncated key length", path)
+		}
+		keyLen := int(binary.BigEndian.Uint16(body[0:2]))
+		body = body[2:]
+
+		if len(body) < keyLen {
+			return nil, fmt.Errorf("key index %s: truncated key", path)
+		}
+		key := body[:keyLen]
+		body = body[keyLen:]
+
+		offset, n := binary.Uvarint(body)
+		if n <= 0 {
+			return nil, fmt.Errorf("key index %s: invalid offset", path)
+		}
+		body = body[n:]
+
+		entries = append(entries, keyIndexEntry{key: key, offset: int64(offset)})
+	}
+
+	return entries, nil
+}
+
+// rebuildKeyIndex reconstructs seg's sparse key index by scanning every
+// record in the segment from the start, the same way Segment.Read
+// rebuilds seg.offset - it's the fallback LoadSegments takes when a
+// segment's .idx sidecar is missing or fails validation.
+func rebuildKeyIndex(seg *Segment, interval int) ([]keyIndexEntry, error) {
+	if _, err := seg.fd.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	defer seg.fd.Seek(0, io.SeekEnd)
+
+	reader := bufio.NewReader(seg.fd)
+
+	var (
+		offset  int64
+		kept    int
+		entries []keyIndexEntry
+	)
+
+	for {
+		start := offset
+
+		entry, err := seg.ReadEntry(reader, offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		offset += int64(entry.Offset)
+
+		if entry.Key == nil {
+			continue
+		}
+
+		if kept%interval == 0 {
+			entries = append(entries, keyIndexEntry{key: entry.Key, offset: start})
+		}
+		kept++
+	}
+
+	return entries, nil
+}
+
+// finalizeKeyIndex runs when seg is about to close: it persists seg's
+// dense in-memory key index as a sparse sidecar and thins seg.keyIndex
+// down to that same sparse subset, since only the active segment needs
+// every key in memory.
+func (w *WAL) finalizeKeyIndex(seg *Segment) error {
+	if len(seg.keyIndex) == 0 {
+		return nil
+	}
+
+	interval := w.option.keyIndexInterval
+	sparse := make([]keyIndexEntry, 0, len(seg.keyIndex)/interval+1)
+	for i, entry := range seg.keyIndex {
+		if i%interval == 0 {
+			sparse = append(sparse, entry)
+		}
+	}
+
+	if err := writeKeySidecar(keyIndexPath(seg.path), sparse, interval); err != nil {
+		return err
+	}
+
+	seg.keyIndex = sparse
+
+	return nil
+}
+
+// searchKeyIndex returns the offset of the sparse entry with the largest
+// key <= target; ReadKey then linearly scans forward from that offset
+// looking for an exact match.
+func searchKeyIndex(entries []keyIndexEntry, target []byte) (int64, bool) {
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].key, target) > 0
+	})
+	if i == 0 {
+		return 0, false
+	}
+
+	return entries[i-1].offset, true
+}
+
+// readEntryAtIndex fetches the entry at ordinal index idx within seg,
+// reading from the active write buffer or the segment file depending on
+// where that index currently lives, mirroring the buffer-vs-disk split
+// ReadIndex and Iter already use.
+func (w *WAL) readEntryAtIndex(seg *Segment, idx int) (*LogEntry, error) {
+	offset := seg.offset[idx]
+
+	if !seg.closed && seg.OnActiveBuffer(idx) {
+		byt := w.buffer.buf.Bytes()[offset.offset-seg.currSize : offset.EndOffset()-seg.currSize]
+		reader := bufioReaderPool.Get().(*bufio.Reader)
+		reader.Reset(bytes.NewReader(byt))
+		entry, err := seg.ReadEntry(reader, offset.offset)
+		bufioReaderPool.Put(reader)
+		return entry, err
+	}
+
+	return seg.SeekOffset(offset.offset)
+}
+
+// ReadKey looks up the entry written under key by scanning segments
+// newest-to-oldest and, within each, binary-searching its sparse key
+// index for the block whose largest key is <= key before linearly
+// scanning forward for an exact match. Keys must have been written in
+// non-decreasing order within a segment (see WriteKeyed) for the binary
+// search to hold.
+func (w *WAL) ReadKey(key []byte) (*LogEntry, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		seg := w.segments[i]
+
+		start, ok := searchKeyIndex(seg.keyIndex, key)
+		if !ok {
+			continue
+		}
+
+		from := sort.Search(len(seg.offset), func(i int) bool { return seg.offset[i].offset >= start })
+
+		if seg.closed {
+			if err := seg.Open(); err != nil {
+				return nil, err
+			}
+		}
+
+		entry, err := w.scanSegmentForKey(seg, from, key)
+
+		if seg.closed {
+			seg.Close()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			return entry, nil
+		}
+	}
+
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+// scanSegmentForKey walks seg's entries forward from ordinal index from,
+// skipping unkeyed entries, until it finds key, passes it (keys are
+// assumed sorted within a segment, so nothing later can match), or runs
+// out of entries.
+func (w *WAL) scanSegmentForKey(seg *Segment, from int, key []byte) (*LogEntry, error) {
+	for idx := from; idx < seg.Size(); idx++ {
+		entry, err := w.readEntryAtIndex(seg, idx)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Key == nil {
+			continue
+		}
+
+		switch bytes.Compare(entry.Key, key) {
+		case 0:
+			return entry, nil
+		case 1:
+			return nil, nil
+		}
+	}
+
+	return nil, nil
+}
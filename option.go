@@ -7,12 +7,16 @@ import (
 
 type WALOption struct {
 	cacheSize          int
+	checkpointOnRotate func(ctx RotateContext) (upto int, keep func(entry *LogEntry) bool)
+	compression        CompressionCodec
 	dir                string
 	janitorHook        func(segment *Segment)
+	keyIndexInterval   int
 	maxFileLifetime    int8
 	maxSegmentFile     uint16
 	maxSegmentSize     int64
 	maxWriteBufferSize int64
+	pageSize           int64
 	prefix             string
 }
 
@@ -25,6 +29,9 @@ var DefaultWalOption = &WALOption{
 	cacheSize:          10 * 1024 * 1024,   // 10 MB (cache size)
 	maxFileLifetime:    0,                  // No janitor to cleanup the logs file
 	janitorHook:        DefaultJanitorHook, // The default behavior is to remove the segment files. For customization, you can add an alternative method.
+	compression:        CompressionNone,    // No compression, records are written as-is
+	pageSize:           32 * 1024,          // 32KB page size (records larger than this are fragmented across pages)
+	keyIndexInterval:   16,                 // every 16th keyed entry is sparse-indexed on disk
 }
 
 // Remove the segment file
@@ -35,6 +42,10 @@ var DefaultJanitorHook = func(seg *Segment) {
 	if err != nil {
 		log.Printf("error remove segment %s cause error %s\n", seg.path, err.Error())
 	}
+
+	if err := removeSegmentSidecars(seg.path); err != nil {
+		log.Printf("error remove segment sidecars for %s cause error %s\n", seg.path, err.Error())
+	}
 }
 
 type WALOpt func(opt *WALOption)
@@ -84,3 +95,65 @@ func WithCustomJanitorHook(hook func(seg *Segment)) WALOpt {
 		opt.janitorHook = hook
 	}
 }
+
+// WithCompression sets the codec used to compress each record's payload
+// before it is framed onto disk. The codec is stored per-record, so
+// switching codecs on an existing WAL does not invalidate previously
+// written records - they keep decoding with whichever codec they were
+// written under.
+func WithCompression(codec CompressionCodec) WALOpt {
+	return func(opt *WALOption) {
+		opt.compression = codec
+	}
+}
+
+// WithPageSize sets the fixed page size new segments are laid out in.
+// Records that don't fit in the remaining space of the current page are
+// split into First/Middle/Last fragments that continue on the following
+// pages. Unlike WithCompression, the page size isn't recorded per record -
+// each segment persists the page size it was created with in a sidecar file
+// next to it, so LoadSegments keeps parsing that segment's fragments
+// correctly even if a later run of the same program passes a different
+// WithPageSize.
+func WithPageSize(size int64) WALOpt {
+	return func(opt *WALOption) {
+		opt.pageSize = size
+	}
+}
+
+// RotateContext is the read-only snapshot of WAL state a
+// WithCheckpointOnRotate hook receives. It's deliberately not a *WAL: the
+// hook runs from flushBuffer while w.mu is already held, and w.mu isn't
+// reentrant, so handing the hook anything capable of calling back into a
+// locking method - Write, Iter, ReadIndex, ReadKey, OpenReader, Checkpoint -
+// would deadlock the WAL permanently. ActiveSegmentIndex and
+// CurrentPosition mirror the WAL methods of the same name at the moment the
+// rotation that triggered the hook happened.
+type RotateContext struct {
+	ActiveSegmentIndex int
+	CurrentPosition    int
+}
+
+// WithCheckpointOnRotate registers a hook that fires from flushBuffer right
+// after a segment rotation, in place of the default all-or-nothing
+// deleteSegments behavior once maxSegmentFile is exceeded. fn decides which
+// segments to fold into the checkpoint (upto) and which of their entries
+// survive (keep); returning a nil keep skips checkpointing for that
+// rotation and falls through to the next one.
+func WithCheckpointOnRotate(fn func(ctx RotateContext) (upto int, keep func(entry *LogEntry) bool)) WALOpt {
+	return func(opt *WALOption) {
+		opt.checkpointOnRotate = fn
+	}
+}
+
+// WithKeyIndexInterval sets how often a WriteKeyed entry is recorded in a
+// segment's sparse on-disk key index: every n-th keyed entry, in write
+// order, gets an index entry. A smaller interval speeds up ReadKey's
+// linear scan at the cost of a larger sidecar file; n must be positive.
+func WithKeyIndexInterval(n int) WALOpt {
+	return func(opt *WALOption) {
+		if n > 0 {
+			opt.keyIndexInterval = n
+		}
+	}
+}
@@ -20,19 +20,51 @@ type Segment struct {
 	offset   []pos
 	currSize int64
 	modTime  time.Time
+	pageSize int64
+	closed   bool
+
+	// keyIndex locates WriteKeyed entries by key. While the segment is
+	// active it holds every keyed entry in write order; once the segment
+	// closes it is thinned down to the sparse subset persisted alongside
+	// it, see finalizeKeyIndex.
+	keyIndex []keyIndexEntry
 }
 
 func (s *Segment) OnActiveBuffer(idx int) bool {
 	return s.offset[idx].offset > s.currSize || s.currSize == 0
 }
 
+// Open reopens a closed segment's file for reading. Callers that read a
+// closed segment wrap the access in Open/Close so the fd is only held while
+// actually in use.
+func (s *Segment) Open() error {
+	fd, err := os.OpenFile(s.path, recoverLogPermission, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.fd = fd
+	return nil
+}
+
+// Close closes the fd opened by Open.
+func (s *Segment) Close() error {
+	return s.fd.Close()
+}
+
+// pos locates one logical record within a segment: offset is the absolute
+// byte position the record's fragments were encoded from (which may land on
+// page padding that the reader skips transparently), and span is the total
+// number of on-disk bytes - fragment headers, payload and any padding - the
+// record occupies, so EndOffset is an O(1) lookup for buffer slicing.
 type pos struct {
 	offset int64
 	length uint32
+	span   int64
 }
 
 func (p *pos) EndOffset() int64 {
-	return p.offset + blockSize + int64(p.length)
+	return p.offset + p.span
 }
 
 func (s *Segment) Read() error {
@@ -42,7 +74,9 @@ func (s *Segment) Read() error {
 	)
 
 	for {
-		entry, err := s.ReadEntry(reader)
+		start := offset
+
+		entry, err := s.ReadEntry(reader, offset)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -50,17 +84,18 @@ func (s *Segment) Read() error {
 			return err
 		}
 
-		calcChecksum := crc32.ChecksumIEEE(entry.Data)
+		calcChecksum := crc32.ChecksumIEEE(entry.raw)
 		if entry.Checksum != calcChecksum {
-			return fmt.Errorf("checksum mismatch at offset %d", offset)
+			return fmt.Errorf("checksum mismatch at offset %d", start)
 		}
 
+		offset += int64(entry.Offset)
+
 		s.offset = append(s.offset, pos{
-			offset: offset,
+			offset: start,
 			length: entry.Length,
+			span:   offset - start,
 		})
-
-		offset += int64(entry.Offset)
 	}
 
 	s.size = offset
@@ -73,31 +108,56 @@ func (s *Segment) Read() error {
 	return nil
 }
 
-func (s *Segment) ReadEntry(reader *bufio.Reader) (*LogEntry, error) {
-	header := make([]byte, 16)
-	_, err := io.ReadFull(reader, header)
+// ReadEntry reassembles the logical record starting at offset, one fragment
+// at a time, validating each fragment's checksum independently before the
+// reassembled bytes are parsed as [timestamp|length|checksum|codec]+payload.
+func (s *Segment) ReadEntry(reader *bufio.Reader, offset int64) (*LogEntry, error) {
+	logical, end, err := readLogical(reader, s.pageSize, offset)
 	if err != nil {
 		return nil, err
 	}
 
-	timestamp := int64(binary.BigEndian.Uint64(header[0:8]))
-	length := binary.BigEndian.Uint32(header[8:12])
-	checksum := binary.BigEndian.Uint32(header[12:16])
+	if len(logical) < blockSize {
+		return nil, fmt.Errorf("corrupt record at offset %d: short logical header", offset)
+	}
+
+	timestamp := int64(binary.BigEndian.Uint64(logical[0:8]))
+	length := binary.BigEndian.Uint32(logical[8:12])
+	checksum := binary.BigEndian.Uint32(logical[12:16])
+	codecByte := logical[16]
+	codec := CompressionCodec(codecByte &^ hasKeyFlag)
+
+	raw := logical[blockSize : blockSize+int(length)]
 
-	data := make([]byte, length)
-	_, err = io.ReadFull(reader, data)
+	data, err := decompress(codec, raw)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("decompress entry: %w", err)
 	}
 
-	offset := int(blockSize + length)
+	var key []byte
+	if codecByte&hasKeyFlag != 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("corrupt record at offset %d: short key header", offset)
+		}
+
+		keyLen := int(binary.BigEndian.Uint16(data[0:2]))
+		if len(data) < 2+keyLen {
+			return nil, fmt.Errorf("corrupt record at offset %d: truncated key", offset)
+		}
+
+		key = data[2 : 2+keyLen]
+		data = data[2+keyLen:]
+	}
 
 	return &LogEntry{
-		Offset:    offset,
+		Offset:    int(end - offset),
 		Timestamp: timestamp,
 		Checksum:  checksum,
 		Data:      data,
+		Key:       key,
+		raw:       raw,
 		Length:    length,
+		codec:     CompressionCodec(codecByte),
 	}, nil
 }
 
@@ -108,7 +168,7 @@ func (s *Segment) SeekOffset(offset int64) (*LogEntry, error) {
 	}
 
 	reader := bufio.NewReader(s.fd)
-	return s.ReadEntry(reader)
+	return s.ReadEntry(reader, offset)
 }
 
 func (s *Segment) Size() int {
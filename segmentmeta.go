@@ -0,0 +1,48 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// segmentMetaPath returns the sidecar path a segment's page size is
+// persisted under, e.g. "prefix-000006.log" -> "prefix-000006.pagesize".
+func segmentMetaPath(segPath string) string {
+	return strings.TrimSuffix(segPath, filepath.Ext(segPath)) + ".pagesize"
+}
+
+// writeSegmentMeta records the page size a segment's fragments were laid
+// out with. WithPageSize is a per-process option, not a per-record one like
+// WithCompression, so without this a WAL directory reopened with a
+// different page size would silently misparse the zero-padding between
+// fragments instead of erroring.
+func writeSegmentMeta(path string, pageSize int64) error {
+	var buf [12]byte
+	binary.BigEndian.PutUint64(buf[0:8], uint64(pageSize))
+	binary.BigEndian.PutUint32(buf[8:12], crc32.ChecksumIEEE(buf[0:8]))
+
+	return os.WriteFile(path, buf[:], 0644)
+}
+
+// readSegmentMeta loads the page size written by writeSegmentMeta, rejecting
+// it if the trailing CRC doesn't match.
+func readSegmentMeta(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) != 12 {
+		return 0, fmt.Errorf("segment meta %s: truncated", path)
+	}
+
+	if crc32.ChecksumIEEE(data[0:8]) != binary.BigEndian.Uint32(data[8:12]) {
+		return 0, fmt.Errorf("segment meta %s: checksum mismatch", path)
+	}
+
+	return int64(binary.BigEndian.Uint64(data[0:8])), nil
+}
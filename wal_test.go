@@ -1,8 +1,12 @@
 package wal
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -77,6 +81,257 @@ func TestWrite(t *testing.T) {
 	})
 }
 
+func TestCompression(t *testing.T) {
+	t.Run("Mixed Codec Records Read Back Correctly After Reopen", func(t *testing.T) {
+		dir := "./tests/logs-compression"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+		)
+		require.NoError(t, err)
+
+		total := 100
+		for i := 0; i < total/2; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+		require.NoError(t, w.Close())
+
+		w, err = New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithCompression(CompressionSnappy),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		for i := total / 2; i < total; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+
+		for i := 0; i < total; i++ {
+			entry, err := w.ReadIndex(i)
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("%s %d", testLogEntryMessage, i), string(entry.Data))
+		}
+
+		idx := 0
+		err = w.Iter(func(index int, entry *LogEntry) bool {
+			assert.Equal(t, fmt.Sprintf("%s %d", testLogEntryMessage, idx), string(entry.Data))
+			idx++
+			return true
+		})
+		require.NoError(t, err)
+		assert.Equal(t, total, idx)
+	})
+}
+
+func TestFragmentedRecords(t *testing.T) {
+	t.Run("Entry Larger Than Page Size Splits Across Pages And Reads Back Whole", func(t *testing.T) {
+		dir := "./tests/logs-fragments"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithPageSize(512),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		small := testLogEntryMessage
+		large := strings.Repeat("x", 3*512+17)
+
+		require.NoError(t, w.Write([]byte(small)))
+		require.NoError(t, w.Write([]byte(large)))
+		require.NoError(t, w.Write([]byte(small)))
+
+		entry, err := w.ReadIndex(0)
+		require.NoError(t, err)
+		assert.Equal(t, small, string(entry.Data))
+
+		entry, err = w.ReadIndex(1)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(entry.Data))
+
+		entry, err = w.ReadIndex(2)
+		require.NoError(t, err)
+		assert.Equal(t, small, string(entry.Data))
+
+		actuals := make([]string, 0)
+		err = w.Iter(func(index int, entry *LogEntry) bool {
+			actuals = append(actuals, string(entry.Data))
+			return true
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{small, large, small}, actuals)
+	})
+
+	t.Run("Reopening With A Different WithPageSize Still Reads Fragments Laid Out Under The Old One", func(t *testing.T) {
+		dir := "./tests/logs-fragments-reopen"
+		defer os.RemoveAll(dir)
+
+		small := testLogEntryMessage
+		large := strings.Repeat("x", 3*512+17)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithPageSize(512),
+		)
+		require.NoError(t, err)
+		require.NoError(t, w.Write([]byte(small)))
+		require.NoError(t, w.Write([]byte(large)))
+		require.NoError(t, w.Close())
+
+		w, err = New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithPageSize(1024),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		entry, err := w.ReadIndex(0)
+		require.NoError(t, err)
+		assert.Equal(t, small, string(entry.Data))
+
+		entry, err = w.ReadIndex(1)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(entry.Data))
+	})
+
+	t.Run("A Corrupt Page Size Sidecar Fails Loading Instead Of Silently Falling Back", func(t *testing.T) {
+		dir := "./tests/logs-fragments-corrupt-meta"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithPageSize(512),
+		)
+		require.NoError(t, err)
+		require.NoError(t, w.Write([]byte(testLogEntryMessage)))
+		require.NoError(t, w.Close())
+
+		require.NoError(t, os.WriteFile(segmentMetaPath(filepath.Join(dir, "examples-wal-000000.log")), []byte("not a valid sidecar"), 0644))
+
+		_, err = New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithPageSize(1024),
+		)
+		require.Error(t, err)
+	})
+}
+
+func TestStreamingReader(t *testing.T) {
+	t.Run("WriteFrom And OpenReader Round Trip A Large Entry", func(t *testing.T) {
+		dir := "./tests/logs-streaming"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithPageSize(512),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte(testLogEntryMessage)))
+
+		large := strings.Repeat("y", 3*512+17)
+		index, err := w.WriteFrom(strings.NewReader(large), int64(len(large)))
+		require.NoError(t, err)
+
+		reader, err := w.OpenReader(index)
+		require.NoError(t, err)
+
+		got, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, large, string(got))
+
+		require.NoError(t, w.Sync())
+
+		reader, err = w.OpenReader(index)
+		require.NoError(t, err)
+
+		got, err = io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, large, string(got))
+	})
+
+	t.Run("OpenReader Strips The Key Prefix From A WriteKeyed Entry", func(t *testing.T) {
+		dir := "./tests/logs-streaming-keyed"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		index, err := w.write([]byte("key-0001"), []byte(testLogEntryMessage))
+		require.NoError(t, err)
+
+		reader, err := w.OpenReader(index)
+		require.NoError(t, err)
+
+		got, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, testLogEntryMessage, string(got))
+	})
+
+	t.Run("OpenReader Strips The Key Prefix From A Compressed WriteKeyed Entry", func(t *testing.T) {
+		dir := "./tests/logs-streaming-keyed-compressed"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(5*1024*1024),
+			WithMaxSegmentFile(5),
+			WithCompression(CompressionSnappy),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		index, err := w.write([]byte("key-0001"), []byte(testLogEntryMessage))
+		require.NoError(t, err)
+
+		reader, err := w.OpenReader(index)
+		require.NoError(t, err)
+
+		got, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, testLogEntryMessage, string(got))
+	})
+}
+
 func TestIter(t *testing.T) {
 	t.Run("Success Iteration on File", func(t *testing.T) {
 		runLayoutTest(t, 200000, func(wal *WAL) {
@@ -147,3 +402,229 @@ func TestIter(t *testing.T) {
 		})
 	})
 }
+
+func TestReadKey(t *testing.T) {
+	t.Run("Finds Keyed Entries Across Rotated Segments By Binary Search", func(t *testing.T) {
+		dir := "./tests/logs-keyindex"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxSegmentFile(5),
+			WithMaxWriteBufferSize(64),
+			WithKeyIndexInterval(4),
+		)
+		require.NoError(t, err)
+
+		total := 80
+		for i := 0; i < total; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			require.NoError(t, w.WriteKeyed(key, []byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+		require.NoError(t, w.Sync())
+
+		for i := 0; i < total; i++ {
+			entry, err := w.ReadKey([]byte(fmt.Sprintf("key-%04d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("%s %d", testLogEntryMessage, i), string(entry.Data))
+		}
+
+		_, err = w.ReadKey([]byte("key-9999"))
+		assert.Error(t, err)
+
+		require.NoError(t, w.Close())
+
+		w, err = New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxSegmentFile(5),
+			WithMaxWriteBufferSize(64),
+			WithKeyIndexInterval(4),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		entry, err := w.ReadKey([]byte("key-0042"))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%s 42", testLogEntryMessage), string(entry.Data))
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Run("Compacted Segments Keep Only Entries The Predicate Selects", func(t *testing.T) {
+		dir := "./tests/logs-checkpoint"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxSegmentFile(5),
+			WithMaxWriteBufferSize(64),
+		)
+		require.NoError(t, err)
+
+		total := 40
+		for i := 0; i < total; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+		require.NoError(t, w.Sync())
+
+		upto := w.segments[len(w.segments)-2].index
+
+		result, err := w.Checkpoint(context.Background(), upto, func(entry *LogEntry) bool {
+			return entry.Data[len(entry.Data)-1]%2 == 0
+		})
+		require.NoError(t, err)
+		assert.Greater(t, result.SegmentsCompacted, 0)
+		assert.Greater(t, result.EntriesDropped, 0)
+
+		require.NoError(t, w.Close())
+
+		w, err = New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxSegmentFile(5),
+			WithMaxWriteBufferSize(64),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		actuals := make([]string, 0)
+		idx := 0
+		err = w.Iter(func(index int, entry *LogEntry) bool {
+			if idx < result.EntriesKept {
+				assert.Zero(t, entry.Data[len(entry.Data)-1]%2, "entry %d should satisfy the keep predicate: %q", idx, entry.Data)
+			}
+			actuals = append(actuals, string(entry.Data))
+			idx++
+			return true
+		})
+		require.NoError(t, err)
+		assert.Equal(t, total-result.EntriesDropped, len(actuals))
+	})
+
+	t.Run("WithCheckpointOnRotate Fires Without Deadlocking And Folds Segments Past maxSegmentFile", func(t *testing.T) {
+		dir := "./tests/logs-checkpoint-on-rotate"
+		defer os.RemoveAll(dir)
+
+		hookCalls := 0
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxSegmentFile(2),
+			WithMaxWriteBufferSize(64),
+			WithCheckpointOnRotate(func(ctx RotateContext) (int, func(entry *LogEntry) bool) {
+				hookCalls++
+				return ctx.ActiveSegmentIndex, func(entry *LogEntry) bool {
+					return entry.Data[len(entry.Data)-1]%2 == 0
+				}
+			}),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		total := 80
+		for i := 0; i < total; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+		require.NoError(t, w.Sync())
+
+		assert.Greater(t, hookCalls, 0)
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		sawCheckpoint := false
+		for _, e := range entries {
+			if e.IsDir() && strings.HasPrefix(e.Name(), "checkpoint.") {
+				sawCheckpoint = true
+			}
+		}
+		assert.True(t, sawCheckpoint, "expected a checkpoint directory under %s", dir)
+	})
+}
+
+func TestSegmentSidecarCleanup(t *testing.T) {
+	t.Run("deleteSegments Removes The Idx And PageSize Sidecars Of Every Dropped Segment", func(t *testing.T) {
+		dir := "./tests/logs-sidecar-cleanup-rotate"
+		defer os.RemoveAll(dir)
+
+		// maxSegmentFile is left at its default (no deletion triggered by
+		// flushBuffer's own rotation logic) so the test can call
+		// deleteSegments directly and check its cleanup in isolation,
+		// rather than relying on the automatic rotation path.
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxWriteBufferSize(64),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		for i := 0; i < 200; i++ {
+			require.NoError(t, w.WriteKeyed([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+		require.NoError(t, w.Sync())
+		require.Greater(t, len(w.segments), 1, "test needs more than one segment to exercise cleanup")
+
+		paths := make([]string, 0, len(w.segments))
+		for _, seg := range w.segments {
+			paths = append(paths, seg.path)
+		}
+
+		require.NoError(t, w.deleteSegments())
+		// deleteSegments never runs without flushBuffer immediately handing
+		// the WAL a fresh active segment to write into - recreate that here
+		// so the deferred w.Close() has a current segment to flush.
+		require.NoError(t, w.createSegment())
+
+		for _, path := range paths {
+			_, err := os.Stat(keyIndexPath(path))
+			assert.True(t, os.IsNotExist(err), "expected %s to be removed alongside its segment", keyIndexPath(path))
+
+			_, err = os.Stat(segmentMetaPath(path))
+			assert.True(t, os.IsNotExist(err), "expected %s to be removed alongside its segment", segmentMetaPath(path))
+		}
+	})
+
+	t.Run("Checkpoint Removes The Sidecars Of Every Segment It Folds Away", func(t *testing.T) {
+		dir := "./tests/logs-sidecar-cleanup-checkpoint"
+		defer os.RemoveAll(dir)
+
+		w, err := New(
+			WithDir(dir),
+			WithPrefix("examples-wal"),
+			WithMaxSegmentSize(1024),
+			WithMaxSegmentFile(5),
+			WithMaxWriteBufferSize(64),
+		)
+		require.NoError(t, err)
+		defer w.Close()
+
+		for i := 0; i < 40; i++ {
+			require.NoError(t, w.WriteKeyed([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("%s %d", testLogEntryMessage, i))))
+		}
+		require.NoError(t, w.Sync())
+
+		droppedPath := w.segments[0].path
+
+		upto := w.segments[len(w.segments)-2].index
+		_, err = w.Checkpoint(context.Background(), upto, func(entry *LogEntry) bool {
+			return entry.Data[len(entry.Data)-1]%2 == 0
+		})
+		require.NoError(t, err)
+
+		_, err = os.Stat(keyIndexPath(droppedPath))
+		assert.True(t, os.IsNotExist(err), "expected %s to be removed alongside the folded segment", keyIndexPath(droppedPath))
+
+		_, err = os.Stat(segmentMetaPath(droppedPath))
+		assert.True(t, os.IsNotExist(err), "expected %s to be removed alongside the folded segment", segmentMetaPath(droppedPath))
+	})
+}